@@ -0,0 +1,201 @@
+package process
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/AsterNighT/software-engineering-backend/api"
+	"github.com/AsterNighT/software-engineering-backend/pkg/account"
+	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// TemplateActivitySubmitJSON
+// a single ordered activity submitted together with a case history template
+type TemplateActivitySubmitJSON struct {
+	Order          int    `json:"order"`
+	Activity       string `json:"activity"`
+	DefaultChecked bool   `json:"default_checked"`
+}
+
+// CreateCaseHistoryTemplate
+// @Summary create a case history template
+// @Tags Process
+// @Description a doctor creates a reusable checklist of activities
+// @Param departmentID body uint false "department this template is scoped to"
+// @Param title body string true "template title"
+// @Param activities body []TemplateActivitySubmitJSON true "ordered activities"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=CaseHistoryTemplate}
+// @Router /casehistorytemplate [POST]
+func (h *ProcessHandler) CreateCaseHistoryTemplate(c echo.Context) error {
+	type TemplateSubmitJSON struct {
+		DepartmentID *uint                        `json:"department_id"`
+		Title        string                       `json:"title"`
+		Activities   []TemplateActivitySubmitJSON `json:"activities"`
+	}
+
+	var submit TemplateSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	var doctor account.Doctor
+	if err := db.Where("account_id = ?", c.Get("id").(uint)).First(&doctor).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", DoctorNotFound))
+	}
+
+	template := CaseHistoryTemplate{
+		DoctorID:     doctor.ID,
+		DepartmentID: submit.DepartmentID,
+		Title:        submit.Title,
+	}
+	for _, activity := range submit.Activities {
+		template.Activities = append(template.Activities, TemplateActivity{
+			Order:          activity.Order,
+			Activity:       activity.Activity,
+			DefaultChecked: activity.DefaultChecked,
+		})
+	}
+
+	if err := db.Create(&template).Error; err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("CreateCaseHistoryTemplate")
+	return c.JSON(http.StatusOK, api.Return("ok", template))
+}
+
+// GetCaseHistoryTemplates
+// @Summary list case history templates
+// @Tags Process
+// @Description list templates, optionally scoped to a department, so the frontend can suggest
+// department-scoped templates when a doctor opens a fresh registration
+// @Param departmentID query uint false "department ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]CaseHistoryTemplate}
+// @Router /casehistorytemplate [GET]
+func (h *ProcessHandler) GetCaseHistoryTemplates(c echo.Context) error {
+	db := utils.GetDB()
+	query := db.Preload("Activities")
+	if departmentID := c.QueryParam("departmentID"); departmentID != "" {
+		query = query.Where("department_id = ?", departmentID)
+	}
+
+	var templates []CaseHistoryTemplate
+	query.Find(&templates)
+
+	c.Logger().Debug("GetCaseHistoryTemplates")
+	return c.JSON(http.StatusOK, api.Return("ok", templates))
+}
+
+// EditCaseHistoryTemplate
+// @Summary edit a case history template
+// @Tags Process
+// @Description a doctor edits an existing template's title and activities
+// @Param templateID body uint true "template's ID"
+// @Param title body string true "template title"
+// @Param activities body []TemplateActivitySubmitJSON true "ordered activities"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /casehistorytemplate [PUT]
+func (h *ProcessHandler) EditCaseHistoryTemplate(c echo.Context) error {
+	type TemplateEditJSON struct {
+		TemplateID uint                          `json:"template_id"`
+		Title      string                        `json:"title"`
+		Activities []TemplateActivitySubmitJSON `json:"activities"`
+	}
+
+	var submit TemplateEditJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	var template CaseHistoryTemplate
+	if err := db.First(&template, submit.TemplateID).Error; err != nil {
+		return c.JSON(http.StatusNotFound, api.Return("error", CaseHistoryTemplateNotFound))
+	}
+
+	activities := make([]TemplateActivity, 0, len(submit.Activities))
+	for _, activity := range submit.Activities {
+		activities = append(activities, TemplateActivity{
+			TemplateID:     template.ID,
+			Order:          activity.Order,
+			Activity:       activity.Activity,
+			DefaultChecked: activity.DefaultChecked,
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", template.ID).Delete(&TemplateActivity{}).Error; err != nil {
+			return err
+		}
+		template.Title = submit.Title
+		template.Activities = activities
+		return tx.Save(&template).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("EditCaseHistoryTemplate")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// DeleteCaseHistoryTemplate
+// @Summary delete a case history template
+// @Tags Process
+// @Description a doctor deletes a template and its activities
+// @Param templateID path uint true "template's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /casehistorytemplate/{templateID} [DELETE]
+func (h *ProcessHandler) DeleteCaseHistoryTemplate(c echo.Context) error {
+	db := utils.GetDB()
+	templateID := c.Param("templateID")
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", templateID).Delete(&TemplateActivity{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&CaseHistoryTemplate{}, templateID).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("DeleteCaseHistoryTemplate")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// expandCaseHistoryTemplate
+// turn a template's ordered activities into a batch of milestones for a registration
+func expandCaseHistoryTemplate(tx *gorm.DB, registrationID uint, templateID uint) ([]MileStone, error) {
+	var template CaseHistoryTemplate
+	if err := tx.Preload("Activities").First(&template, templateID).Error; err != nil {
+		return nil, CaseHistoryTemplateNotFound
+	}
+
+	milestones := make([]MileStone, 0, len(template.Activities))
+	for _, activity := range template.Activities {
+		milestones = append(milestones, MileStone{
+			RegistrationID: registrationID,
+			Activity:       activity.Activity,
+			Checked:        activity.DefaultChecked,
+			Order:          activity.Order,
+			// synthesize a ClientUUID so the (RegistrationID, ClientUUID) unique index holds even
+			// though these milestones didn't come from a device's offline op queue; each
+			// TemplateActivity's ID is unique within its template, so one expansion is unique per
+			// registration; expanding the same template into the same registration again yields the
+			// same ClientUUIDs, the insert violates the index and the transaction fails
+			ClientUUID: fmt.Sprintf("template:%d:activity:%d", templateID, activity.ID),
+		})
+	}
+	if err := tx.Create(&milestones).Error; err != nil {
+		return nil, err
+	}
+	return milestones, nil
+}
@@ -0,0 +1,27 @@
+package process
+
+import "errors"
+
+// sentinel errors returned by the process package handlers
+var (
+	DepartmentNotFound    = errors.New("department not found")
+	PatientNotFound       = errors.New("patient not found")
+	DoctorNotFound        = errors.New("doctor not found")
+	DuplicateRegistration = errors.New("duplicate registration")
+	InvalidSchedule       = errors.New("invalid schedule")
+	NotEnoughCapacity     = errors.New("not enough capacity")
+	CannotAssignDoctor    = errors.New("cannot assign doctor")
+	InvalidRegistration   = errors.New("invalid registration")
+	InvalidSubmitFormat   = errors.New("invalid submit format")
+
+	InvalidRegistrationTransition = errors.New("registration cannot move to the requested status")
+	PrescriptionNotFound          = errors.New("prescription not found")
+	DrugNotFound                  = errors.New("drug not found")
+	ChargeAlreadySettled          = errors.New("charge already settled")
+
+	CaseHistoryTemplateNotFound = errors.New("case history template not found")
+
+	ScheduleRuleNotFound = errors.New("schedule rule not found")
+	InvalidScheduleScope = errors.New("invalid schedule rule scope")
+	DoctorScheduleFull   = errors.New("doctor schedule is full")
+)
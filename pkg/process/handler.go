@@ -4,20 +4,18 @@ import (
 	"math"
 	"net/http"
 	"strconv"
-	"sync"
 
 	"github.com/AsterNighT/software-engineering-backend/api"
 	"github.com/AsterNighT/software-engineering-backend/pkg/account"
 	_ "github.com/AsterNighT/software-engineering-backend/pkg/cases"
 	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ProcessHandler struct{}
 
-// prevent
-var registrationMutex sync.Mutex
-
 // GetAllDepartments
 // @Summary get all departments
 // @Tags Process
@@ -144,72 +142,119 @@ func (h *ProcessHandler) CreateRegistration(c echo.Context) error {
 		}
 	}
 
-	// use mutex to prevent conflict
-	registrationMutex.Lock()
+	var registration Registration
+	var waitlistEntry Waitlist
+	waitlisted := false
+
+	// a transaction with a row lock on the schedule replaces the former process-wide mutex:
+	// only the goroutine holding the lock on this exact (DepartmentID, Year, Month, Day, HalfDay)
+	// row can read-then-write its Current counter, so concurrent requests for other schedules
+	// are no longer serialized behind one another
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var schedule DepartmentSchedule
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&DepartmentSchedule{
+			DepartmentID: department.ID,
+			Year:         submit.Year,
+			Month:        submit.Month,
+			Day:          submit.Day,
+			HalfDay:      submit.HalfDay,
+		}).First(&schedule).Error
+
+		if err != nil || !validateSchedule(&schedule) {
+			// registration on past days are not allowed
+			return InvalidSchedule
+		}
 
-	// check schedule
-	var schedule DepartmentSchedule
-	err = db.Where(&DepartmentSchedule{
-		DepartmentID: department.ID,
-		Year:         submit.Year,
-		Month:        submit.Month,
-		Day:          submit.Day,
-		HalfDay:      submit.HalfDay,
-	}).First(&schedule).Error
+		if schedule.Current >= schedule.Capacity {
+			// the schedule is full: join the FIFO waitlist instead of failing outright
+			var tail int64
+			tx.Model(&Waitlist{}).Where("schedule_id = ?", schedule.ID).Count(&tail)
+			waitlistEntry = Waitlist{
+				ScheduleID: schedule.ID,
+				PatientID:  patient.ID,
+				Position:   int(tail) + 1,
+			}
+			if err := tx.Create(&waitlistEntry).Error; err != nil {
+				return err
+			}
+			waitlisted = true
+			return nil
+		}
 
-	// invalid schedule return and unlock
-	if err != nil || !validateSchedule(&schedule) {
-		registrationMutex.Unlock()
-		// registration on past days are not allowed
-		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSchedule))
-	} else if schedule.Current >= schedule.Capacity {
-		registrationMutex.Unlock()
-		return c.JSON(http.StatusBadRequest, api.Return("error", NotEnoughCapacity))
-	}
+		// assign the doctor with the minimal registrations
+		var doctors []account.Doctor
+		tx.Where("department_id = ?", department.ID).Find(&doctors)
+		var doctorRegistrationCount = make([]int64, len(doctors))
+
+		registration = Registration{
+			DepartmentID: department.ID,
+			Year:         submit.Year,
+			Month:        submit.Month,
+			Day:          submit.Day,
+			HalfDay:      submit.HalfDay,
+		}
 
-	// assign the doctor with the minimal registrations
-	var doctors []account.Doctor
-	db.Where("department_id = ?", department.ID).Find(&doctors)
-	var doctorRegistrationCount = make([]int64, len(doctors))
+		// find min count of registrations
+		minCount, minIndex := int64(math.MaxInt64), -1
+		for i := range doctors {
+			tx.Model(&Registration{}).Where(&registration).Where("doctor_id = ?", doctors[i].ID).Count(&doctorRegistrationCount[i])
+			if minCount > doctorRegistrationCount[i] {
+				minCount = doctorRegistrationCount[i]
+				minIndex = i
+			}
+		}
 
-	registration := Registration{
-		DepartmentID: department.ID,
-		Year:         submit.Year,
-		Month:        submit.Month,
-		Day:          submit.Day,
-		HalfDay:      submit.HalfDay,
-	}
+		// cannot find a doctor
+		if minIndex == -1 {
+			return CannotAssignDoctor
+		}
 
-	// find min count of registrations
-	minCount, minIndex := int64(math.MaxInt64), -1
-	for i := range doctors {
-		db.Model(&Registration{}).Where(&registration).Count(&doctorRegistrationCount[i])
-		if minCount > doctorRegistrationCount[i] {
-			minCount = doctorRegistrationCount[i]
-			minIndex = i
+		registration.PatientID = patient.ID
+		registration.DoctorID = doctors[minIndex].ID
+
+		// reject if this individual doctor is full even when the department still has room
+		var doctorSchedule DoctorSchedule
+		hasDoctorSchedule := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(&DoctorSchedule{
+			DoctorID: registration.DoctorID,
+			Year:     submit.Year,
+			Month:    submit.Month,
+			Day:      submit.Day,
+			HalfDay:  submit.HalfDay,
+		}).First(&doctorSchedule).Error == nil
+		if hasDoctorSchedule && doctorSchedule.Current >= doctorSchedule.Capacity {
+			return DoctorScheduleFull
 		}
-	}
 
-	// cannot find a doctor
-	if minIndex == -1 {
-		registrationMutex.Unlock()
-		return c.JSON(http.StatusBadRequest, api.Return("error", CannotAssignDoctor))
-	}
+		if err := tx.Create(&registration).Error; err != nil {
+			c.Logger().Error("Registration insert failed!")
+			return InvalidRegistration
+		}
+
+		if hasDoctorSchedule {
+			doctorSchedule.Current++
+			if err := tx.Save(&doctorSchedule).Error; err != nil {
+				return err
+			}
+		}
 
-	// process schedule
-	registration.PatientID = patient.ID
-	registration.DoctorID = doctors[minIndex].ID
+		schedule.Current = schedule.Current + 1
+		return tx.Save(&schedule).Error
+	})
 
-	if err := db.Create(&registration).Error; err != nil {
-		c.Logger().Error("Registration insert failed!")
-		registrationMutex.Unlock()
-		return c.JSON(http.StatusNotAcceptable, api.Return("error", InvalidRegistration))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
 	}
 
-	schedule.Current = schedule.Current + 1
-	if err := db.Save(&schedule).Error; err != nil {
-		registrationMutex.Unlock()
-		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSchedule))
+	if waitlisted {
+		type WaitlistedJSON struct {
+			WaitlistID uint `json:"waitlist_id"`
+			Position   int  `json:"position"`
+		}
+		c.Logger().Debug("CreateRegistration: waitlisted")
+		return c.JSON(http.StatusOK, api.Return("ok", WaitlistedJSON{
+			WaitlistID: waitlistEntry.ID,
+			Position:   waitlistEntry.Position,
+		}))
 	}
 
 	type RegistrationSuccessJSON struct {
@@ -243,7 +288,6 @@ func (h *ProcessHandler) CreateRegistration(c echo.Context) error {
 	}
 
 	c.Logger().Debug("CreateRegistration")
-	registrationMutex.Unlock()
 	return c.JSON(http.StatusOK, api.Return("ok", success))
 }
 
@@ -374,16 +418,37 @@ func (h *ProcessHandler) UpdateRegistrationStatus(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, api.Return("error", err))
 	}
-	registration.Status = status
+	if !validateRegistrationTransition(registration.Status, status) {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidRegistrationTransition))
+	}
 	if status == terminated {
-		if terminatedCause != "" {
-			registration.Status = status
-			registration.TerminatedCause = terminatedCause
-		} else {
+		if terminatedCause == "" {
 			return c.JSON(http.StatusBadRequest, api.Return("ok", "Missing terminated causes"))
 		}
-	} else {
-		registration.Status = status
+		registration.TerminatedCause = terminatedCause
+	}
+	registration.Status = status
+	if err := db.Save(&registration).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+	if status == terminated {
+		var schedule DepartmentSchedule
+		if err := db.Where(&DepartmentSchedule{
+			DepartmentID: registration.DepartmentID,
+			Year:         registration.Year,
+			Month:        registration.Month,
+			Day:          registration.Day,
+			HalfDay:      registration.HalfDay,
+		}).First(&schedule).Error; err == nil {
+			// free the slot this registration held, then try to fill it from the waitlist
+			if schedule.Current > 0 {
+				schedule.Current--
+				db.Save(&schedule)
+			}
+			if err := PromoteWaitlist(db, schedule.ID); err != nil {
+				c.Logger().Error("PromoteWaitlist failed after termination:", err)
+			}
+		}
 	}
 	return c.JSON(http.StatusCreated, api.Return("ok", nil))
 }
@@ -391,9 +456,11 @@ func (h *ProcessHandler) UpdateRegistrationStatus(c echo.Context) error {
 // CreateMileStoneByDoctor
 // @Summary create milestone
 // @Tags Process
-// @Description the doctor create milestone (type: array)
+// @Description the doctor create milestone (type: array). If templateID is given, activity is
+// ignored and the template's ordered activities are expanded into milestones instead
 // @Param registrationID body uint true "registration's ID"
 // @Param activity body string true "milestone's activity"
+// @Param templateID query uint false "case history template to expand instead of a single activity"
 // @Produce json
 // @Success 204 {string} api.ReturnedData{}
 // @Router /milestone [POST]
@@ -403,6 +470,23 @@ func (h *ProcessHandler) CreateMileStoneByDoctor(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, api.Return("error", err))
 	}
+
+	if templateID, err := strconv.ParseUint(c.QueryParam("templateID"), 10, 64); err == nil {
+		var milestones []MileStone
+		txErr := db.Transaction(func(tx *gorm.DB) error {
+			expanded, err := expandCaseHistoryTemplate(tx, uint(registrationID), uint(templateID))
+			if err != nil {
+				return err
+			}
+			milestones = expanded
+			return nil
+		})
+		if txErr != nil {
+			return c.JSON(http.StatusUnprocessableEntity, api.Return("error", txErr))
+		}
+		return c.JSON(http.StatusCreated, api.Return("ok", milestones))
+	}
+
 	milestone := MileStone{
 		RegistrationID: uint(registrationID),
 		Activity:       c.QueryParam("activity"),
@@ -3,6 +3,8 @@ package process
 import (
 	"github.com/AsterNighT/software-engineering-backend/pkg/account"
 	"time"
+
+	"gorm.io/gorm"
 )
 
 // Department
@@ -22,7 +24,9 @@ type Registration struct {
 	DoctorID     uint
 	PatientID    uint
 	DepartmentID uint
-	Date         time.Time
+	Year         int
+	Month        int
+	Day          int
 	HalfDay      HalfDayEnum            // TODO: a validator for registration, only half day is allowed
 	Status       RegistrationStatusEnum `gorm:"default:'committed'"`
 	// every registration will eventually be terminated, and therefore needs a cause
@@ -33,10 +37,132 @@ type Registration struct {
 // MileStone
 // milestone that represent a small step during the process
 type MileStone struct {
-	ID             uint `gorm:"primaryKey"`
-	RegistrationID uint
+	ID             uint   `gorm:"primaryKey"`
+	RegistrationID uint   `gorm:"uniqueIndex:idx_milestone_client_uuid,priority:1"`
 	Activity       string `gorm:"default:''"`
 	Checked        bool   `gorm:"default:false"`
+	// ClientUUID identifies the client-side op that created this milestone (unique per device+op).
+	// Uniqueness is scoped per registration (see RegistrationID's tag) since it drives
+	// last-writer-wins conflict resolution keyed by (RegistrationID, ClientUUID), and an offline
+	// device can replay its push without creating duplicates
+	ClientUUID string `gorm:"uniqueIndex:idx_milestone_client_uuid,priority:2"`
+	// Rev increases monotonically per registration and drives last-writer-wins conflict resolution
+	Rev       int64
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	// Order preserves the activity's position when it was expanded from a CaseHistoryTemplate
+	Order int
+}
+
+// CaseHistoryTemplate
+// a reusable, doctor-authored checklist of activities that can be expanded into milestones
+type CaseHistoryTemplate struct {
+	ID           uint `gorm:"primaryKey"`
+	DoctorID     uint
+	DepartmentID *uint
+	Title        string
+	Activities   []TemplateActivity
+}
+
+// TemplateActivity
+// a single ordered activity within a CaseHistoryTemplate
+type TemplateActivity struct {
+	ID             uint `gorm:"primaryKey"`
+	TemplateID     uint
+	Order          int
+	Activity       string
+	DefaultChecked bool `gorm:"default:false"`
+}
+
+// Drug
+// a single entry of the drug catalog a doctor can prescribe from
+type Drug struct {
+	ID          uint `gorm:"primaryKey"`
+	Name        string
+	Spec        string  // e.g. dosage/packaging description
+	Unit        string  // e.g. box, bottle, tablet
+	UnitPrice   float64 // price charged per unit
+	InsuredRate float64 `gorm:"default:0"` // portion of UnitPrice covered by insurance, 0~1
+}
+
+// Prescription
+// a prescription issued by a doctor for a registration
+type Prescription struct {
+	ID             uint `gorm:"primaryKey"`
+	RegistrationID uint
+	DoctorID       uint
+	Advice         string `gorm:"default:''"`
+	Items          []PrescriptionItem
+	CreatedAt      time.Time
+}
+
+// PrescriptionItem
+// a single drug entry within a prescription
+type PrescriptionItem struct {
+	ID             uint `gorm:"primaryKey"`
+	PrescriptionID uint
+	DrugID         uint
+	Drug           Drug
+	Quantity       int
+}
+
+// Charge
+// a charge record derived from a registration's settled prescriptions
+type Charge struct {
+	ID               uint `gorm:"primaryKey"`
+	RegistrationID   uint
+	Total            float64
+	PatientPayable   float64
+	InsurancePayable float64
+	Settled          bool `gorm:"default:false"`
+	SettledAt        time.Time
+}
+
+// DoctorSchedule
+// schedule table for a single doctor, summed per day/halfday into the owning DepartmentSchedule
+type DoctorSchedule struct {
+	ID       uint `gorm:"primaryKey"`
+	DoctorID uint
+	Year     int
+	Month    int
+	Day      int
+	HalfDay  HalfDayEnum
+	Capacity int
+	Current  int // current number of registrations assigned to this doctor for this schedule duration
+}
+
+// ScheduleScopeEnum
+// whether a ScheduleRule generates DepartmentSchedule or DoctorSchedule rows
+type ScheduleScopeEnum string
+
+const (
+	scopeDepartment ScheduleScopeEnum = "department"
+	scopeDoctor     ScheduleScopeEnum = "doctor"
+)
+
+// ScheduleRule
+// a recurring rule materialized into concrete DepartmentSchedule/DoctorSchedule rows for the next N days
+type ScheduleRule struct {
+	ID        uint `gorm:"primaryKey"`
+	ScopeType ScheduleScopeEnum
+	ScopeID   uint // DepartmentID or DoctorID, depending on ScopeType
+	// Weekdays is a bitmask, bit 0 = Sunday ... bit 6 = Saturday, matching time.Weekday
+	Weekdays  int
+	HalfDay   HalfDayEnum
+	Capacity  int
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Waitlist
+// a patient waiting for a slot to free up on a full DepartmentSchedule
+type Waitlist struct {
+	ID         uint `gorm:"primaryKey"`
+	ScheduleID uint
+	PatientID  uint
+	Position   int
+	CreatedAt  time.Time
+	NotifiedAt *time.Time
 }
 
 // DepartmentSchedule
@@ -45,7 +171,9 @@ type MileStone struct {
 type DepartmentSchedule struct {
 	ID           uint `gorm:"primaryKey"`
 	DepartmentID uint
-	Date         time.Time
+	Year         int
+	Month        int
+	Day          int
 	HalfDay      HalfDayEnum // TODO: a validator for department, only half day is allowed
 	Capacity     int
 	// DepartmentSchedule.Capacity = SUM(DoctorSchedule.Capacity if the doctor belongs to this department)
@@ -59,9 +187,32 @@ type RegistrationStatusEnum string
 const (
 	committed  RegistrationStatusEnum = "committed"
 	accepted   RegistrationStatusEnum = "accepted"
+	prescribed RegistrationStatusEnum = "prescribed"
+	settled    RegistrationStatusEnum = "settled"
 	terminated RegistrationStatusEnum = "terminated"
 )
 
+// registrationTransitions
+// the allowed next statuses for every registration status, mirroring
+// the hospital flow: registered -> accepted -> prescribed -> settled -> terminated
+var registrationTransitions = map[RegistrationStatusEnum][]RegistrationStatusEnum{
+	committed:  {accepted, terminated},
+	accepted:   {prescribed, terminated},
+	prescribed: {settled, terminated},
+	settled:    {terminated},
+}
+
+// validateRegistrationTransition
+// check whether a registration is allowed to move from its current status to next
+func validateRegistrationTransition(current, next RegistrationStatusEnum) bool {
+	for _, allowed := range registrationTransitions[current] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
 // HalfDayEnum
 // define new enum for half day selection
 type HalfDayEnum string
@@ -0,0 +1,337 @@
+package process
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AsterNighT/software-engineering-backend/api"
+	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// PrescriptionItemSubmitJSON
+// a single drug line submitted together with a prescription
+type PrescriptionItemSubmitJSON struct {
+	DrugID   uint `json:"drug_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// CreatePrescription
+// @Summary create prescription
+// @Tags Process
+// @Description the doctor prescribes drugs for a registration and moves it to the prescribed state
+// @Param registrationID body uint true "registration's ID"
+// @Param advice body string false "doctor's advice"
+// @Param items body []PrescriptionItemSubmitJSON true "prescribed drugs"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=Prescription}
+// @Router /prescription [POST]
+func (h *ProcessHandler) CreatePrescription(c echo.Context) error {
+	type PrescriptionSubmitJSON struct {
+		RegistrationID uint                         `json:"registration_id"`
+		Advice         string                       `json:"advice"`
+		Items          []PrescriptionItemSubmitJSON `json:"items"`
+	}
+
+	var submit PrescriptionSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+
+	var registration Registration
+	if err := db.First(&registration, submit.RegistrationID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidRegistration))
+	}
+	// a registration may carry several prescriptions; only the first one drives the
+	// accepted -> prescribed transition, later ones are added to an already-prescribed registration
+	firstPrescription := registration.Status != prescribed
+	if firstPrescription && !validateRegistrationTransition(registration.Status, prescribed) {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidRegistrationTransition))
+	}
+
+	prescription := Prescription{
+		RegistrationID: submit.RegistrationID,
+		DoctorID:       registration.DoctorID,
+		Advice:         submit.Advice,
+	}
+	for _, item := range submit.Items {
+		var drug Drug
+		if err := db.First(&drug, item.DrugID).Error; err != nil {
+			return c.JSON(http.StatusBadRequest, api.Return("error", DrugNotFound))
+		}
+		prescription.Items = append(prescription.Items, PrescriptionItem{
+			DrugID:   item.DrugID,
+			Quantity: item.Quantity,
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&prescription).Error; err != nil {
+			return err
+		}
+		if !firstPrescription {
+			return nil
+		}
+		registration.Status = prescribed
+		return tx.Save(&registration).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("CreatePrescription")
+	return c.JSON(http.StatusOK, api.Return("ok", prescription))
+}
+
+// EditPrescription
+// @Summary edit prescription
+// @Tags Process
+// @Description the doctor edits an existing prescription's advice and items
+// @Param prescriptionID body uint true "prescription's ID"
+// @Param advice body string false "doctor's advice"
+// @Param items body []PrescriptionItemSubmitJSON true "prescribed drugs"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /prescription [PUT]
+func (h *ProcessHandler) EditPrescription(c echo.Context) error {
+	type PrescriptionEditJSON struct {
+		PrescriptionID uint                         `json:"prescription_id"`
+		Advice         string                       `json:"advice"`
+		Items          []PrescriptionItemSubmitJSON `json:"items"`
+	}
+
+	var submit PrescriptionEditJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	var prescription Prescription
+	if err := db.First(&prescription, submit.PrescriptionID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", PrescriptionNotFound))
+	}
+
+	items := make([]PrescriptionItem, 0, len(submit.Items))
+	for _, item := range submit.Items {
+		var drug Drug
+		if err := db.First(&drug, item.DrugID).Error; err != nil {
+			return c.JSON(http.StatusBadRequest, api.Return("error", DrugNotFound))
+		}
+		items = append(items, PrescriptionItem{
+			PrescriptionID: prescription.ID,
+			DrugID:         item.DrugID,
+			Quantity:       item.Quantity,
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("prescription_id = ?", prescription.ID).Delete(&PrescriptionItem{}).Error; err != nil {
+			return err
+		}
+		prescription.Advice = submit.Advice
+		prescription.Items = items
+		return tx.Save(&prescription).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("EditPrescription")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// DeletePrescription
+// @Summary delete prescription
+// @Tags Process
+// @Description the doctor deletes a prescription and its items
+// @Param prescriptionID path uint true "prescription's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /prescription/{prescriptionID} [DELETE]
+func (h *ProcessHandler) DeletePrescription(c echo.Context) error {
+	db := utils.GetDB()
+	prescriptionID := c.Param("prescriptionID")
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("prescription_id = ?", prescriptionID).Delete(&PrescriptionItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Prescription{}, prescriptionID).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("DeletePrescription")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// ListPrescriptionsByRegistration
+// @Summary list prescriptions of a registration
+// @Tags Process
+// @Description display all prescriptions (with items) belonging to a registration
+// @Param registrationID path uint true "registration's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]Prescription}
+// @Router /prescriptions/{registrationID} [GET]
+func (h *ProcessHandler) ListPrescriptionsByRegistration(c echo.Context) error {
+	db := utils.GetDB()
+	var prescriptions []Prescription
+	db.Preload("Items").Preload("Items.Drug").Where("registration_id = ?", c.Param("registrationID")).Find(&prescriptions)
+
+	c.Logger().Debug("ListPrescriptionsByRegistration")
+	return c.JSON(http.StatusOK, api.Return("ok", prescriptions))
+}
+
+// GetChargeInfo
+// @Summary get charge info
+// @Tags Process
+// @Description compute (or return the already computed) patient-payable and insurance-payable totals for a registration
+// @Param registrationID path uint true "registration's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=Charge}
+// @Router /charge/{registrationID} [GET]
+func (h *ProcessHandler) GetChargeInfo(c echo.Context) error {
+	db := utils.GetDB()
+	registrationID, err := strconv.ParseUint(c.Param("registrationID"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidRegistration))
+	}
+
+	var charge Charge
+	if err := db.Where("registration_id = ?", registrationID).First(&charge).Error; err == nil {
+		c.Logger().Debug("GetChargeInfo")
+		return c.JSON(http.StatusOK, api.Return("ok", charge))
+	}
+
+	charge, err = computeCharge(db, uint(registrationID))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+
+	c.Logger().Debug("GetChargeInfo")
+	return c.JSON(http.StatusOK, api.Return("ok", charge))
+}
+
+// SettleCharges
+// @Summary settle charges
+// @Tags Process
+// @Description the cashier settles a registration's charges and moves it to the settled state
+// @Param registrationID body uint true "registration's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=Charge}
+// @Router /charge/settle [POST]
+func (h *ProcessHandler) SettleCharges(c echo.Context) error {
+	type SettleSubmitJSON struct {
+		RegistrationID uint `json:"registration_id"`
+	}
+
+	var submit SettleSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	var registration Registration
+	if err := db.First(&registration, submit.RegistrationID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidRegistration))
+	}
+	if !validateRegistrationTransition(registration.Status, settled) {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidRegistrationTransition))
+	}
+
+	var charge Charge
+	found := db.Where("registration_id = ?", submit.RegistrationID).First(&charge).Error == nil
+	if !found {
+		computed, err := computeCharge(db, submit.RegistrationID)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, api.Return("error", err))
+		}
+		charge = computed
+	}
+	if charge.Settled {
+		return c.JSON(http.StatusBadRequest, api.Return("error", ChargeAlreadySettled))
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		charge.Settled = true
+		charge.SettledAt = time.Now()
+		if found {
+			if err := tx.Save(&charge).Error; err != nil {
+				return err
+			}
+		} else {
+			if err := tx.Create(&charge).Error; err != nil {
+				return err
+			}
+		}
+		registration.Status = settled
+		return tx.Save(&registration).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("SettleCharges")
+	return c.JSON(http.StatusOK, api.Return("ok", charge))
+}
+
+// computeCharge
+// sum up a registration's prescription items and split the total into patient-payable and insurance-payable parts
+func computeCharge(db *gorm.DB, registrationID uint) (Charge, error) {
+	var prescriptions []Prescription
+	if err := db.Preload("Items").Preload("Items.Drug").Where("registration_id = ?", registrationID).Find(&prescriptions).Error; err != nil {
+		return Charge{}, err
+	}
+
+	var total, insurancePayable float64
+	for _, prescription := range prescriptions {
+		for _, item := range prescription.Items {
+			lineTotal := item.Drug.UnitPrice * float64(item.Quantity)
+			total += lineTotal
+			insurancePayable += lineTotal * item.Drug.InsuredRate
+		}
+	}
+
+	return Charge{
+		RegistrationID:   registrationID,
+		Total:            total,
+		InsurancePayable: insurancePayable,
+		PatientPayable:   total - insurancePayable,
+	}, nil
+}
+
+// HisHospitalPatientList
+// @Summary list patients for the doctor's workstation
+// @Tags Process
+// @Description return registrations filtered by department, date and status, for the doctor's His workstation
+// @Param departmentID query uint true "department ID"
+// @Param date query string true "date, format YYYY-MM-DD"
+// @Param status query string false "registration status"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]Registration}
+// @Router /his/patients [GET]
+func (h *ProcessHandler) HisHospitalPatientList(c echo.Context) error {
+	db := utils.GetDB()
+
+	date, err := time.Parse("2006-01-02", c.QueryParam("date"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	query := db.Where("department_id = ? AND year = ? AND month = ? AND day = ?",
+		c.QueryParam("departmentID"), date.Year(), int(date.Month()), date.Day())
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var registrations []Registration
+	query.Find(&registrations)
+
+	c.Logger().Debug("HisHospitalPatientList")
+	return c.JSON(http.StatusOK, api.Return("ok", registrations))
+}
@@ -0,0 +1,217 @@
+package process
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AsterNighT/software-engineering-backend/api"
+	"github.com/AsterNighT/software-engineering-backend/pkg/account"
+	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const defaultMaterializeDays = 30
+
+// ScheduleRuleSubmitJSON
+// the shape a rule is submitted and returned in
+type ScheduleRuleSubmitJSON struct {
+	ScopeType ScheduleScopeEnum `json:"scope_type"`
+	ScopeID   uint              `json:"scope_id"`
+	Weekdays  int               `json:"weekdays"`
+	HalfDay   HalfDayEnum       `json:"halfday"`
+	Capacity  int               `json:"capacity"`
+	StartDate time.Time         `json:"start_date"`
+	EndDate   time.Time         `json:"end_date"`
+}
+
+// CreateScheduleRule
+// @Summary create a schedule rule
+// @Tags Process
+// @Description define a recurring department- or doctor-level schedule rule and materialize it immediately
+// @Param rule body ScheduleRuleSubmitJSON true "rule definition"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=ScheduleRule}
+// @Router /schedule/rules [POST]
+func (h *ProcessHandler) CreateScheduleRule(c echo.Context) error {
+	var submit ScheduleRuleSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+	if submit.ScopeType != scopeDepartment && submit.ScopeType != scopeDoctor {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidScheduleScope))
+	}
+
+	rule := ScheduleRule{
+		ScopeType: submit.ScopeType,
+		ScopeID:   submit.ScopeID,
+		Weekdays:  submit.Weekdays,
+		HalfDay:   submit.HalfDay,
+		Capacity:  submit.Capacity,
+		StartDate: submit.StartDate,
+		EndDate:   submit.EndDate,
+	}
+
+	db := utils.GetDB()
+	if err := db.Create(&rule).Error; err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+	if err := MaterializeSchedules(db, defaultMaterializeDays); err != nil {
+		c.Logger().Error("MaterializeSchedules failed after rule create:", err)
+	}
+
+	c.Logger().Debug("CreateScheduleRule")
+	return c.JSON(http.StatusOK, api.Return("ok", rule))
+}
+
+// GetScheduleRules
+// @Summary list schedule rules
+// @Tags Process
+// @Description list department-scoped schedule rules, optionally filtered by department
+// @Param departmentID query uint false "department ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]ScheduleRule}
+// @Router /schedule/rules [GET]
+func (h *ProcessHandler) GetScheduleRules(c echo.Context) error {
+	db := utils.GetDB()
+	query := db.Where("scope_type = ?", scopeDepartment)
+	if departmentID := c.QueryParam("departmentID"); departmentID != "" {
+		query = query.Where("scope_id = ?", departmentID)
+	}
+
+	var rules []ScheduleRule
+	query.Find(&rules)
+
+	c.Logger().Debug("GetScheduleRules")
+	return c.JSON(http.StatusOK, api.Return("ok", rules))
+}
+
+// MaterializeSchedulesEndpoint
+// @Summary materialize schedule rules
+// @Tags Process
+// @Description admin endpoint: generate concrete DepartmentSchedule/DoctorSchedule rows for the next N days
+// and recompute department capacities. Also meant to be run from a nightly cron
+// @Param days query int false "number of days to materialize, default 30"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /schedule/materialize [POST]
+func (h *ProcessHandler) MaterializeSchedulesEndpoint(c echo.Context) error {
+	days := defaultMaterializeDays
+	if parsed, err := strconv.Atoi(c.QueryParam("days")); err == nil && parsed > 0 {
+		days = parsed
+	}
+
+	db := utils.GetDB()
+	if err := MaterializeSchedules(db, days); err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("MaterializeSchedulesEndpoint")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// MaterializeSchedules
+// generate concrete DepartmentSchedule/DoctorSchedule rows for the next `days` days from every
+// ScheduleRule, then recompute DepartmentSchedule.Capacity = SUM(DoctorSchedule.Capacity) for
+// every day/halfday touched. CreateScheduleRule runs it after storing a new rule and
+// MaterializeSchedulesEndpoint runs it on demand; nothing in this package runs it on a timer,
+// and there is no rule update path that runs it
+func MaterializeSchedules(db *gorm.DB, days int) error {
+	var rules []ScheduleRule
+	if err := db.Find(&rules).Error; err != nil {
+		return err
+	}
+
+	today := time.Now().Truncate(24 * time.Hour)
+	horizon := today.AddDate(0, 0, days)
+	touchedDepartments := make(map[uint]bool)
+
+	for _, rule := range rules {
+		start := rule.StartDate
+		if today.After(start) {
+			start = today
+		}
+		end := rule.EndDate
+		if horizon.Before(end) {
+			end = horizon
+		}
+
+		for date := start; !date.After(end); date = date.AddDate(0, 0, 1) {
+			if rule.Weekdays&(1<<uint(date.Weekday())) == 0 {
+				continue
+			}
+			year, month, day := date.Year(), int(date.Month()), date.Day()
+
+			switch rule.ScopeType {
+			case scopeDepartment:
+				var schedule DepartmentSchedule
+				err := db.Where(&DepartmentSchedule{
+					DepartmentID: rule.ScopeID,
+					Year:         year,
+					Month:        month,
+					Day:          day,
+					HalfDay:      rule.HalfDay,
+				}).Attrs(DepartmentSchedule{Capacity: rule.Capacity}).FirstOrCreate(&schedule).Error
+				if err != nil {
+					return err
+				}
+				touchedDepartments[rule.ScopeID] = true
+			case scopeDoctor:
+				var schedule DoctorSchedule
+				err := db.Where(&DoctorSchedule{
+					DoctorID: rule.ScopeID,
+					Year:     year,
+					Month:    month,
+					Day:      day,
+					HalfDay:  rule.HalfDay,
+				}).Attrs(DoctorSchedule{Capacity: rule.Capacity}).FirstOrCreate(&schedule).Error
+				if err != nil {
+					return err
+				}
+				var doctor account.Doctor
+				if err := db.First(&doctor, rule.ScopeID).Error; err == nil {
+					touchedDepartments[doctor.DepartmentID] = true
+				}
+			}
+		}
+	}
+
+	for departmentID := range touchedDepartments {
+		if err := recomputeDepartmentCapacity(db, departmentID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recomputeDepartmentCapacity
+// DepartmentSchedule.Capacity = SUM(DoctorSchedule.Capacity) across the department's doctors,
+// recomputed for every day/halfday that department currently has a schedule row for
+func recomputeDepartmentCapacity(db *gorm.DB, departmentID uint) error {
+	var doctorIDs []uint
+	if err := db.Model(&account.Doctor{}).Where("department_id = ?", departmentID).Pluck("id", &doctorIDs).Error; err != nil {
+		return err
+	}
+	if len(doctorIDs) == 0 {
+		return nil
+	}
+
+	var schedules []DepartmentSchedule
+	if err := db.Where("department_id = ?", departmentID).Find(&schedules).Error; err != nil {
+		return err
+	}
+
+	for i := range schedules {
+		var capacity int64
+		db.Model(&DoctorSchedule{}).
+			Where("doctor_id IN ? AND year = ? AND month = ? AND day = ? AND half_day = ?",
+				doctorIDs, schedules[i].Year, schedules[i].Month, schedules[i].Day, schedules[i].HalfDay).
+			Select("COALESCE(SUM(capacity), 0)").Row().Scan(&capacity)
+		schedules[i].Capacity = int(capacity)
+		if err := db.Save(&schedules[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
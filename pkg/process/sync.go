@@ -0,0 +1,177 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"github.com/AsterNighT/software-engineering-backend/api"
+	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+)
+
+// mileStoneHash
+// content hash of a milestone, used by the client to detect whether its local copy is stale
+func mileStoneHash(milestone MileStone) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%t|%d", milestone.Activity, milestone.Checked, milestone.Rev)))
+	return hex.EncodeToString(sum[:])
+}
+
+// nextMileStoneRev
+// the next monotonically increasing Rev for a registration
+func nextMileStoneRev(registrationID uint) int64 {
+	db := utils.GetDB()
+	var maxRev int64
+	db.Unscoped().Model(&MileStone{}).Where("registration_id = ?", registrationID).Select("COALESCE(MAX(rev), 0)").Row().Scan(&maxRev)
+	return maxRev + 1
+}
+
+// KnownMileStoneJSON
+// a milestone the client already knows about, identified by its client-assigned UUID
+type KnownMileStoneJSON struct {
+	ClientUUID string `json:"client_uuid"`
+	Hash       string `json:"hash"`
+}
+
+// CheckMileStoneSync
+// @Summary check milestone sync state
+// @Tags Process
+// @Description tell a doctor's device which milestones it needs to push, which it is missing, and which were deleted
+// @Param deviceID body string true "device ID"
+// @Param registrationID body uint true "registration's ID"
+// @Param known body []KnownMileStoneJSON true "client-known milestones with content hashes"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /sync/milestones/check [POST]
+func (h *ProcessHandler) CheckMileStoneSync(c echo.Context) error {
+	type CheckSubmitJSON struct {
+		DeviceID       string               `json:"device_id"`
+		RegistrationID uint                 `json:"registration_id"`
+		Known          []KnownMileStoneJSON `json:"known"`
+	}
+
+	var submit CheckSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	knownByUUID := make(map[string]string, len(submit.Known))
+	for _, k := range submit.Known {
+		knownByUUID[k.ClientUUID] = k.Hash
+	}
+
+	var serverMileStones []MileStone
+	db.Where("registration_id = ?", submit.RegistrationID).Find(&serverMileStones)
+	seen := make(map[string]bool, len(serverMileStones))
+
+	give := make([]MileStone, 0)
+	for _, m := range serverMileStones {
+		seen[m.ClientUUID] = true
+		if hash, ok := knownByUUID[m.ClientUUID]; !ok || hash != mileStoneHash(m) {
+			give = append(give, m)
+		}
+	}
+
+	var tombstones []MileStone
+	db.Unscoped().Where("registration_id = ? AND deleted_at IS NOT NULL", submit.RegistrationID).Find(&tombstones)
+	tombstoned := make(map[string]bool, len(tombstones))
+	deleted := make([]string, 0, len(tombstones))
+	for _, t := range tombstones {
+		tombstoned[t.ClientUUID] = true
+		if _, ok := knownByUUID[t.ClientUUID]; ok {
+			deleted = append(deleted, t.ClientUUID)
+		}
+	}
+
+	want := make([]string, 0)
+	for uuid := range knownByUUID {
+		if !seen[uuid] && !tombstoned[uuid] {
+			want = append(want, uuid)
+		}
+	}
+
+	type CheckResultJSON struct {
+		Want    []string    `json:"want"`
+		Give    []MileStone `json:"give"`
+		Deleted []string    `json:"deleted"`
+	}
+
+	c.Logger().Debug("CheckMileStoneSync")
+	return c.JSON(http.StatusOK, api.Return("ok", CheckResultJSON{Want: want, Give: give, Deleted: deleted}))
+}
+
+// MileStoneOpJSON
+// a single pending create/update/delete operation queued on the device
+type MileStoneOpJSON struct {
+	ClientUUID     string `json:"client_uuid"`
+	RegistrationID uint   `json:"registration_id"`
+	Op             string `json:"op"` // create, update or delete
+	Activity       string `json:"activity"`
+	Checked        bool   `json:"checked"`
+}
+
+// PushMileStoneSync
+// @Summary push pending milestone operations
+// @Tags Process
+// @Description reconcile a batch of offline create/update/delete operations from a doctor's device
+// @Param deviceID body string true "device ID"
+// @Param ops body []MileStoneOpJSON true "pending operations"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /sync/milestones/push [POST]
+func (h *ProcessHandler) PushMileStoneSync(c echo.Context) error {
+	type PushSubmitJSON struct {
+		DeviceID string            `json:"device_id"`
+		Ops      []MileStoneOpJSON `json:"ops"`
+	}
+
+	var submit PushSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+
+	type AppliedJSON struct {
+		ClientUUID string `json:"client_uuid"`
+		ServerID   uint   `json:"server_id"`
+	}
+	applied := make([]AppliedJSON, 0, len(submit.Ops))
+
+	for _, op := range submit.Ops {
+		var existing MileStone
+		// replaying the same push is idempotent: look the op up by its (RegistrationID, ClientUUID) key first
+		found := db.Unscoped().Where("registration_id = ? AND client_uuid = ?", op.RegistrationID, op.ClientUUID).First(&existing).Error == nil
+
+		switch op.Op {
+		case "delete":
+			if found {
+				db.Delete(&existing)
+				applied = append(applied, AppliedJSON{ClientUUID: op.ClientUUID, ServerID: existing.ID})
+			}
+		case "create", "update":
+			if found {
+				existing.Activity = op.Activity
+				existing.Checked = op.Checked
+				existing.Rev = nextMileStoneRev(op.RegistrationID)
+				db.Save(&existing)
+				applied = append(applied, AppliedJSON{ClientUUID: op.ClientUUID, ServerID: existing.ID})
+			} else {
+				milestone := MileStone{
+					RegistrationID: op.RegistrationID,
+					ClientUUID:     op.ClientUUID,
+					Activity:       op.Activity,
+					Checked:        op.Checked,
+					Rev:            nextMileStoneRev(op.RegistrationID),
+				}
+				db.Create(&milestone)
+				applied = append(applied, AppliedJSON{ClientUUID: op.ClientUUID, ServerID: milestone.ID})
+			}
+		}
+	}
+
+	c.Logger().Debug("PushMileStoneSync")
+	return c.JSON(http.StatusOK, api.Return("ok", applied))
+}
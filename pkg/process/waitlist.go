@@ -0,0 +1,152 @@
+package process
+
+import (
+	"log"
+	"math"
+	"net/http"
+
+	"github.com/AsterNighT/software-engineering-backend/api"
+	"github.com/AsterNighT/software-engineering-backend/pkg/account"
+	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// notifyWaitlistPromotion
+// notification hook called whenever a waitlisted patient is promoted to a real registration.
+// left as a plain log line; swap in a push/SMS notifier once one exists
+func notifyWaitlistPromotion(entry Waitlist, registration Registration) {
+	log.Printf("patient %d promoted from waitlist %d to registration %d", entry.PatientID, entry.ID, registration.ID)
+}
+
+// PromoteWaitlist
+// pop the head of the waitlist for a schedule and turn it into a real registration, using the
+// same least-loaded doctor assignment as CreateRegistration. Meant to be called right after a
+// registration on that schedule is terminated, freeing up one slot
+func PromoteWaitlist(db *gorm.DB, scheduleID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var schedule DepartmentSchedule
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&schedule, scheduleID).Error; err != nil {
+			return err
+		}
+		if schedule.Current >= schedule.Capacity {
+			return nil
+		}
+
+		var head Waitlist
+		err := tx.Where("schedule_id = ?", scheduleID).Order("position ASC").First(&head).Error
+		if err != nil {
+			// nobody waiting
+			return nil
+		}
+
+		var doctors []account.Doctor
+		tx.Where("department_id = ?", schedule.DepartmentID).Find(&doctors)
+		var doctorRegistrationCount = make([]int64, len(doctors))
+
+		registration := Registration{
+			DepartmentID: schedule.DepartmentID,
+			Year:         schedule.Year,
+			Month:        schedule.Month,
+			Day:          schedule.Day,
+			HalfDay:      schedule.HalfDay,
+		}
+
+		minCount, minIndex := int64(math.MaxInt64), -1
+		for i := range doctors {
+			tx.Model(&Registration{}).Where(&registration).Where("doctor_id = ?", doctors[i].ID).Count(&doctorRegistrationCount[i])
+			if minCount > doctorRegistrationCount[i] {
+				minCount = doctorRegistrationCount[i]
+				minIndex = i
+			}
+		}
+		if minIndex == -1 {
+			return CannotAssignDoctor
+		}
+
+		registration.PatientID = head.PatientID
+		registration.DoctorID = doctors[minIndex].ID
+		if err := tx.Create(&registration).Error; err != nil {
+			return err
+		}
+
+		schedule.Current++
+		if err := tx.Save(&schedule).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Delete(&head).Error; err != nil {
+			return err
+		}
+
+		// shift everyone behind the promoted patient up by one position
+		if err := tx.Model(&Waitlist{}).Where("schedule_id = ? AND position > ?", scheduleID, head.Position).
+			UpdateColumn("position", gorm.Expr("position - 1")).Error; err != nil {
+			return err
+		}
+
+		notifyWaitlistPromotion(head, registration)
+		return nil
+	})
+}
+
+// GetWaitlistByPatient
+// @Summary get a patient's waitlist entries
+// @Tags Process
+// @Description list the schedules a patient is currently waiting on, with queue position
+// @Param PatientID path uint true "patient's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]Waitlist}
+// @Router /waitlist/patient/{PatientID} [GET]
+func (h *ProcessHandler) GetWaitlistByPatient(c echo.Context) error {
+	db := utils.GetDB()
+	var entries []Waitlist
+	db.Where("patient_id = ?", c.Param("PatientID")).Order("created_at ASC").Find(&entries)
+
+	c.Logger().Debug("GetWaitlistByPatient")
+	return c.JSON(http.StatusOK, api.Return("ok", entries))
+}
+
+// CancelWaitlist
+// @Summary cancel a waitlist entry
+// @Tags Process
+// @Description a patient gives up their position in the waitlist
+// @Param waitlistID path uint true "waitlist entry's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /waitlist/{waitlistID} [DELETE]
+func (h *ProcessHandler) CancelWaitlist(c echo.Context) error {
+	db := utils.GetDB()
+	waitlistID := c.Param("waitlistID")
+
+	var owned Waitlist
+	if err := db.First(&owned, waitlistID).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+	var patient account.Patient
+	if err := db.Where("account_id = ?", c.Get("id").(uint)).First(&patient).Error; err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", PatientNotFound))
+	}
+	if owned.PatientID != patient.ID {
+		return c.JSON(http.StatusForbidden, api.Return("unauthorized", nil))
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var entry Waitlist
+		if err := tx.First(&entry, waitlistID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&entry).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Waitlist{}).Where("schedule_id = ? AND position > ?", entry.ScheduleID, entry.Position).
+			UpdateColumn("position", gorm.Expr("position - 1")).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+
+	c.Logger().Debug("CancelWaitlist")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
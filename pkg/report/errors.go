@@ -0,0 +1,13 @@
+package report
+
+import "errors"
+
+// sentinel errors returned by the report package handlers
+var (
+	InvalidSubmitFormat = errors.New("invalid submit format")
+	ReportNotFound      = errors.New("report not found")
+	WarningNotFound     = errors.New("warning not found")
+	ReportAlreadyClosed = errors.New("report already closed")
+	PatientNotFound     = errors.New("patient not found")
+	DoctorNotFound      = errors.New("doctor not found")
+)
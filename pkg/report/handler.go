@@ -0,0 +1,320 @@
+package report
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/AsterNighT/software-engineering-backend/api"
+	"github.com/AsterNighT/software-engineering-backend/pkg/account"
+	"github.com/AsterNighT/software-engineering-backend/pkg/utils"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const reportPageSize = 20
+
+type ReportHandler struct{}
+
+// currentPatientID
+// resolve the logged-in account to its Patient row ID, the same way process.CreateRegistration does
+func currentPatientID(c echo.Context) (uint, error) {
+	var patient account.Patient
+	if err := utils.GetDB().Where("account_id = ?", c.Get("id").(uint)).First(&patient).Error; err != nil {
+		return 0, PatientNotFound
+	}
+	return patient.ID, nil
+}
+
+// currentDoctorID
+// resolve the logged-in account to its Doctor row ID
+func currentDoctorID(c echo.Context) (uint, error) {
+	var doctor account.Doctor
+	if err := utils.GetDB().Where("account_id = ?", c.Get("id").(uint)).First(&doctor).Error; err != nil {
+		return 0, DoctorNotFound
+	}
+	return doctor.ID, nil
+}
+
+// CreateReport
+// @Summary file a complaint against a doctor
+// @Tags Report
+// @Description a patient files a report against a doctor, optionally tied to a registration
+// @Param targetDoctorID body uint true "target doctor's ID"
+// @Param registrationID body uint false "related registration's ID"
+// @Param reason body string true "complaint reason"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=Report}
+// @Router /reports [POST]
+func (h *ReportHandler) CreateReport(c echo.Context) error {
+	type ReportSubmitJSON struct {
+		TargetDoctorID uint   `json:"target_doctor_id"`
+		RegistrationID *uint  `json:"registration_id"`
+		Reason         string `json:"reason"`
+	}
+
+	var submit ReportSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	patientID, err := currentPatientID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+
+	report := Report{
+		ReporterPatientID: patientID,
+		TargetDoctorID:    submit.TargetDoctorID,
+		RegistrationID:    submit.RegistrationID,
+		Reason:            submit.Reason,
+	}
+
+	db := utils.GetDB()
+	if err := db.Create(&report).Error; err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("CreateReport")
+	return c.JSON(http.StatusOK, api.Return("ok", report))
+}
+
+// ListReports
+// @Summary list reports
+// @Tags Report
+// @Description moderator view of reports, keyset-paginated over id, optionally filtered by closed status
+// @Param closed query bool false "true for closed reports, false (default) for open/investigating reports"
+// @Param before query uint false "return reports with id less than this cursor"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]Report}
+// @Router /reports [GET]
+func (h *ReportHandler) ListReports(c echo.Context) error {
+	if !isModerator(c) {
+		return c.JSON(http.StatusForbidden, api.Return("unauthorized", nil))
+	}
+	db := utils.GetDB()
+
+	query := db.Model(&Report{}).Order("id DESC").Limit(reportPageSize)
+
+	if closed, err := strconv.ParseBool(c.QueryParam("closed")); err == nil && closed {
+		query = query.Where("status IN ?", []ReportStatusEnum{closedNoAction, closedWarningIssued})
+	} else {
+		query = query.Where("status IN ?", []ReportStatusEnum{open, investigating})
+	}
+
+	if before, err := strconv.ParseUint(c.QueryParam("before"), 10, 64); err == nil {
+		query = query.Where("id < ?", before)
+	}
+
+	var reports []Report
+	query.Find(&reports)
+
+	c.Logger().Debug("ListReports")
+	return c.JSON(http.StatusOK, api.Return("ok", reports))
+}
+
+// GetReportByID
+// @Summary get a report by its ID
+// @Tags Report
+// @Description return a report's details, restricted to the reporter, the target doctor (read-only) and moderators
+// @Param reportID path uint true "report's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=Report}
+// @Router /reports/{reportID} [GET]
+func (h *ReportHandler) GetReportByID(c echo.Context) error {
+	if !ReportAccess(c) {
+		return c.JSON(http.StatusForbidden, api.Return("unauthorized", nil))
+	}
+	db := utils.GetDB()
+	var report Report
+	if err := db.First(&report, c.Param("reportID")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, api.Return("error", ReportNotFound))
+	}
+	c.Logger().Debug("GetReportByID")
+	return c.JSON(http.StatusOK, api.Return("ok", report))
+}
+
+// UpdateReport
+// @Summary moderator resolves a report
+// @Tags Report
+// @Description a moderator moves a report to investigating or closes it with a note
+// @Param reportID path uint true "report's ID"
+// @Param status body string true "next status"
+// @Param moderatorNote body string false "moderator's note"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /reports/{reportID} [PUT]
+func (h *ReportHandler) UpdateReport(c echo.Context) error {
+	if !isModerator(c) {
+		return c.JSON(http.StatusForbidden, api.Return("unauthorized", nil))
+	}
+	type ReportUpdateJSON struct {
+		Status        ReportStatusEnum `json:"status"`
+		ModeratorNote string           `json:"moderator_note"`
+	}
+
+	var submit ReportUpdateJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+	// closedWarningIssued is only ever reached via IssueWarning, which also creates the
+	// accompanying Warning row; accepting it here would desync Report.Status from the Warning table
+	if submit.Status != investigating && submit.Status != closedNoAction {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	var report Report
+	if err := db.First(&report, c.Param("reportID")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, api.Return("error", ReportNotFound))
+	}
+
+	report.Status = submit.Status
+	report.ModeratorNote = submit.ModeratorNote
+	if submit.Status == closedNoAction {
+		now := time.Now()
+		report.ResolvedAt = &now
+	}
+
+	if err := db.Save(&report).Error; err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("UpdateReport")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// IssueWarning
+// @Summary issue a warning to the report's target doctor
+// @Tags Report
+// @Description atomically closes the report and issues a warning to the target doctor
+// @Param reportID path uint true "report's ID"
+// @Param message body string true "warning message"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=Warning}
+// @Router /reports/{reportID}/warning [POST]
+func (h *ReportHandler) IssueWarning(c echo.Context) error {
+	if !isModerator(c) {
+		return c.JSON(http.StatusForbidden, api.Return("unauthorized", nil))
+	}
+	type WarningSubmitJSON struct {
+		Message string `json:"message"`
+	}
+
+	var submit WarningSubmitJSON
+	if err := c.Bind(&submit); err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", InvalidSubmitFormat))
+	}
+
+	db := utils.GetDB()
+	var report Report
+	if err := db.First(&report, c.Param("reportID")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, api.Return("error", ReportNotFound))
+	}
+	if report.Status == closedNoAction || report.Status == closedWarningIssued {
+		return c.JSON(http.StatusBadRequest, api.Return("error", ReportAlreadyClosed))
+	}
+
+	warning := Warning{
+		DoctorID: report.TargetDoctorID,
+		ReportID: report.ID,
+		Message:  submit.Message,
+		IssuedAt: time.Now(),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&warning).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		report.Status = closedWarningIssued
+		report.ResolvedAt = &now
+		return tx.Save(&report).Error
+	})
+	if err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("IssueWarning")
+	return c.JSON(http.StatusOK, api.Return("ok", warning))
+}
+
+// GetDoctorWarnings
+// @Summary get a doctor's unacknowledged warnings
+// @Tags Report
+// @Description a doctor views the warnings issued against them that they have not yet acknowledged
+// @Produce json
+// @Success 200 {object} api.ReturnedData{data=[]Warning}
+// @Router /doctor/warnings [GET]
+func (h *ReportHandler) GetDoctorWarnings(c echo.Context) error {
+	doctorID, err := currentDoctorID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+
+	db := utils.GetDB()
+	var warnings []Warning
+	db.Where("doctor_id = ? AND acknowledged = ?", doctorID, false).Find(&warnings)
+
+	c.Logger().Debug("GetDoctorWarnings")
+	return c.JSON(http.StatusOK, api.Return("ok", warnings))
+}
+
+// AckWarning
+// @Summary acknowledge a warning
+// @Tags Report
+// @Description a doctor acknowledges a warning issued against them
+// @Param warningID path uint true "warning's ID"
+// @Produce json
+// @Success 200 {object} api.ReturnedData{}
+// @Router /doctor/warnings/{warningID}/ack [POST]
+func (h *ReportHandler) AckWarning(c echo.Context) error {
+	db := utils.GetDB()
+	var warning Warning
+	if err := db.First(&warning, c.Param("warningID")).Error; err != nil {
+		return c.JSON(http.StatusNotFound, api.Return("error", WarningNotFound))
+	}
+	doctorID, err := currentDoctorID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, api.Return("error", err))
+	}
+	if warning.DoctorID != doctorID {
+		return c.JSON(http.StatusForbidden, api.Return("unauthorized", nil))
+	}
+
+	warning.Acknowledged = true
+	if err := db.Save(&warning).Error; err != nil {
+		return c.JSON(http.StatusUnprocessableEntity, api.Return("error", err))
+	}
+
+	c.Logger().Debug("AckWarning")
+	return c.JSON(http.StatusOK, api.Return("ok", nil))
+}
+
+// ReportAccess
+// the reporter, the target doctor (read-only) and admins may access a given report,
+// mirroring the pattern of process.PatientAccessToRegistration/DoctorAccessToRegistration
+func ReportAccess(c echo.Context) bool {
+	db := utils.GetDB()
+	var report Report
+	if err := db.First(&report, c.Param("reportID")).Error; err != nil {
+		return false
+	}
+	if isModerator(c) {
+		return true
+	}
+	if patientID, err := currentPatientID(c); err == nil && patientID == report.ReporterPatientID {
+		return true
+	}
+	if doctorID, err := currentDoctorID(c); err == nil && doctorID == report.TargetDoctorID {
+		return true
+	}
+	return false
+}
+
+// isModerator
+// only the "admin" role may act as moderator: list or resolve reports and issue warnings
+func isModerator(c echo.Context) bool {
+	role, ok := c.Get("role").(string)
+	return ok && role == "admin"
+}
@@ -0,0 +1,39 @@
+package report
+
+import "time"
+
+// ReportStatusEnum
+// define new enum for report status
+type ReportStatusEnum string
+
+const (
+	open                ReportStatusEnum = "open"
+	investigating       ReportStatusEnum = "investigating"
+	closedNoAction      ReportStatusEnum = "closed_no_action"
+	closedWarningIssued ReportStatusEnum = "closed_warning_issued"
+)
+
+// Report
+// a patient complaint filed against a doctor, optionally tied to a registration
+type Report struct {
+	ID                uint `gorm:"primaryKey"`
+	ReporterPatientID uint
+	TargetDoctorID    uint
+	RegistrationID    *uint
+	Reason            string
+	Status            ReportStatusEnum `gorm:"default:'open'"`
+	ModeratorNote     string           `gorm:"default:''"`
+	CreatedAt         time.Time
+	ResolvedAt        *time.Time
+}
+
+// Warning
+// a warning issued to a doctor as the outcome of a resolved report
+type Warning struct {
+	ID           uint `gorm:"primaryKey"`
+	DoctorID     uint
+	ReportID     uint
+	Message      string
+	IssuedAt     time.Time
+	Acknowledged bool `gorm:"default:false"`
+}